@@ -0,0 +1,103 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command ghost is the Overlord client. It currently implements
+// push-resource, which streams a firmware/resource archive to a DUT
+// through an Overlord server's HTTP/WebSocket front door, using the
+// resumable ModeResource protocol.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"overlord"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "push-resource":
+		cmdPushResource(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ghost push-resource [--server host] [--resume] [--verify] <mid> <file>")
+	os.Exit(2)
+}
+
+func cmdPushResource(args []string) {
+	fs := flag.NewFlagSet("push-resource", flag.ExitOnError)
+	server := fs.String("server", "localhost", "Overlord server host")
+	resume := fs.Bool("resume", false, "resume an interrupted transfer instead of restarting it")
+	verify := fs.Bool("verify", false, "ask the agent to re-hash the target after apply")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+	opts := overlord.PushResourceOptions{
+		MID:    fs.Arg(0),
+		File:   fs.Arg(1),
+		Resume: *resume,
+		Verify: *verify,
+	}
+
+	if err := runPushResource(*server, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "push-resource:", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+func runPushResource(server string, opts overlord.PushResourceOptions) error {
+	f, err := os.Open(opts.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	sum, err := sha256File(f)
+	if err != nil {
+		return fmt.Errorf("hashing %s: %w", opts.File, err)
+	}
+
+	manifest := overlord.ResourceManifest{
+		Name:   filepath.Base(opts.File),
+		Size:   info.Size(),
+		SHA256: sum,
+		Verify: opts.Verify,
+	}
+
+	addr := fmt.Sprintf("%s:%d", server, overlord.OverlordHTTPPort)
+	return overlord.PushResourceOverWS(addr, opts.MID, f, manifest, opts.Resume, func(p overlord.ResourceProgress) {
+		fmt.Printf("\r%-8s %6.1f%% (%d/%d bytes)", p.Stage, p.Percent, p.Sent, p.Total)
+	})
+}
+
+func sha256File(f *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff parameters for agent reconnect, modeled on gRPC's default
+// backoff: each retry multiplies the previous delay, with jitter applied
+// and a hard cap so a long-disconnected factory floor doesn't hammer the
+// server.
+const (
+	backoffBase       = 1 * time.Second
+	backoffMultiplier = 1.6
+	backoffJitter     = 0.2
+	backoffCap        = 120 * time.Second
+)
+
+// nextBackoff returns the delay to wait before the next reconnect attempt
+// given the previous delay. Call with 0 for the first retry.
+func nextBackoff(prev time.Duration) time.Duration {
+	next := backoffBase
+	if prev > 0 {
+		next = time.Duration(float64(prev) * backoffMultiplier)
+	}
+	if next > backoffCap {
+		next = backoffCap
+	}
+
+	delta := backoffJitter * float64(next)
+	next = next - time.Duration(delta) + time.Duration(rand.Float64()*2*delta)
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
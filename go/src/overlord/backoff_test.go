@@ -0,0 +1,38 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoffFirstRetryIsAroundBase(t *testing.T) {
+	d := nextBackoff(0)
+	lo := time.Duration(float64(backoffBase) * (1 - backoffJitter))
+	hi := time.Duration(float64(backoffBase) * (1 + backoffJitter))
+	if d < lo || d > hi {
+		t.Errorf("nextBackoff(0) = %v, want within [%v, %v]", d, lo, hi)
+	}
+}
+
+func TestNextBackoffGrowsAndCaps(t *testing.T) {
+	d := time.Duration(0)
+	for i := 0; i < 50; i++ {
+		d = nextBackoff(d)
+		capHi := time.Duration(float64(backoffCap) * (1 + backoffJitter))
+		if d > capHi {
+			t.Fatalf("nextBackoff exceeded cap: got %v, want <= %v", d, capHi)
+		}
+		if d < 0 {
+			t.Fatalf("nextBackoff went negative: %v", d)
+		}
+	}
+	// After enough iterations it should be hovering near the cap.
+	capLo := time.Duration(float64(backoffCap) * (1 - backoffJitter))
+	if d < capLo {
+		t.Errorf("nextBackoff after 50 retries = %v, want near cap %v", d, backoffCap)
+	}
+}
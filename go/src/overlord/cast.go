@@ -0,0 +1,208 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// CastCodec identifies the video codec negotiated for a cast session.
+type CastCodec string
+
+const (
+	CastCodecH264 CastCodec = "h264"
+	CastCodecVP8  CastCodec = "vp8"
+	CastCodecMJPG CastCodec = "mjpeg"
+)
+
+// CastParams describes the codec/resolution/bitrate/FPS of a cast session.
+// The viewer's first WebSocket message proposes them; the server clamps
+// them with negotiateCast and sends the result back to the viewer before
+// frames start flowing. The negotiated params are not sent to the agent.
+type CastParams struct {
+	Codec     CastCodec `json:"codec"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	BitrateKB int       `json:"bitrate_kb"`
+	FPS       int       `json:"fps"`
+}
+
+// CastInputEvent is injected back to the agent over the same WebSocket
+// that carries outgoing frames, letting an operator drive the DUT's
+// pointer/keyboard from the viewer panel.
+type CastInputEvent struct {
+	// Type is one of "pointer" or "key".
+	Type string `json:"type"`
+	X    int    `json:"x,omitempty"`
+	Y    int    `json:"y,omitempty"`
+	Down bool   `json:"down,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+// defaultCastFPS is the throttle applied when a session does not negotiate
+// an explicit frame rate.
+const defaultCastFPS = 15
+
+// maxCastBitrateKB caps whatever bitrate a session proposes so one
+// runaway DUT can't saturate the operator's link.
+const maxCastBitrateKB = 8000
+
+// maxCastFrameSize bounds a single frame's declared length, so a corrupt
+// stream can't make readCastFrames allocate an unbounded buffer.
+const maxCastFrameSize = 32 << 20 // 32 MiB
+
+// negotiateCast clamps a proposed CastParams to the bounds the server is
+// willing to serve, filling in defaultCastFPS when the proposal doesn't
+// pick one. It's pure so the clamping rules can be unit
+// tested without a live session.
+func negotiateCast(proposed CastParams) CastParams {
+	negotiated := proposed
+	if negotiated.FPS <= 0 {
+		negotiated.FPS = defaultCastFPS
+	}
+	if negotiated.BitrateKB <= 0 || negotiated.BitrateKB > maxCastBitrateKB {
+		negotiated.BitrateKB = maxCastBitrateKB
+	}
+	if negotiated.Codec == "" {
+		negotiated.Codec = CastCodecMJPG
+	}
+	return negotiated
+}
+
+// HandleCastRequest upgrades the HTTP request to a WebSocket and bridges
+// it to the ModeCast agent connection registered under mid: the browser's
+// first message proposes CastParams and the negotiated params are sent
+// back to it, then encoded frames are throttled to
+// the negotiated FPS and relayed to the viewer, while pointer/keyboard
+// CastInputEvents read from the browser are written back to the agent.
+func HandleCastRequest(w http.ResponseWriter, r *http.Request, mid string) {
+	cs, ok := Agents.Lookup(mid)
+	if !ok || cs.Mode != ModeCast {
+		http.NotFound(w, r)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	var proposed CastParams
+	if _, payload, err := ws.ReadMessage(); err == nil {
+		json.Unmarshal(payload, &proposed)
+	}
+	negotiated := negotiateCast(proposed)
+
+	ack, err := json.Marshal(negotiated)
+	if err != nil {
+		return
+	}
+	if err := ws.WriteMessage(wsOpText, ack); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go castReadInput(ws, cs, done)
+	castStreamFrames(ws, cs, negotiated, done)
+}
+
+// castReadInput relays CastInputEvents from the browser's WebSocket to
+// the agent connection until the socket closes or the session ends.
+func castReadInput(ws *wsConn, cs *ConnServer, done chan struct{}) {
+	defer close(done)
+	for {
+		opcode, payload, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if opcode != wsOpText && opcode != wsOpBinary {
+			continue
+		}
+		var event CastInputEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			continue
+		}
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		if _, err := cs.Conn.Write(append(encoded, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// castStreamFrames reads length-prefixed frames from the agent connection
+// and forwards them to the browser, throttled to negotiated.FPS, until
+// done is closed (input side hung up) or the agent connection errs out.
+func castStreamFrames(ws *wsConn, cs *ConnServer, negotiated CastParams, done chan struct{}) {
+	interval := time.Second / time.Duration(negotiated.FPS)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	frames := make(chan []byte, 1)
+	go readCastFrames(cs.Conn, frames)
+
+	var pending []byte
+	for {
+		select {
+		case <-done:
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			pending = frame
+		case <-ticker.C:
+			if pending == nil {
+				continue
+			}
+			if err := ws.WriteMessage(wsOpBinary, pending); err != nil {
+				return
+			}
+			ObserveBytesTransferred(ModeCast, len(pending))
+			pending = nil
+		}
+	}
+}
+
+// readCastFrames reads length-prefixed frames off r (the agent's TCP
+// connection) and pushes each complete one to out: a 4-byte big-endian
+// length followed by that many bytes of payload (castTypeMuxed payloads
+// are expected to already be container-delimited by the codec within
+// that payload and are passed through as-is). A single TCP Read can
+// return less than one frame or more than one, so frames are reassembled
+// with a buffered reader rather than treating each Read's bytes as a
+// complete frame.
+func readCastFrames(r io.Reader, out chan<- []byte) {
+	defer close(out)
+	br := bufio.NewReaderSize(r, 64*1024)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint32(header)
+		if length > maxCastFrameSize {
+			return
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return
+		}
+		select {
+		case out <- frame:
+		default:
+			// Drop the frame rather than block the read loop behind a
+			// slow viewer; the next frame will catch the UI back up.
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestNegotiateCastFillsDefaults(t *testing.T) {
+	got := negotiateCast(CastParams{})
+	if got.FPS != defaultCastFPS {
+		t.Errorf("FPS = %d, want default %d", got.FPS, defaultCastFPS)
+	}
+	if got.BitrateKB != maxCastBitrateKB {
+		t.Errorf("BitrateKB = %d, want %d", got.BitrateKB, maxCastBitrateKB)
+	}
+	if got.Codec != CastCodecMJPG {
+		t.Errorf("Codec = %q, want %q", got.Codec, CastCodecMJPG)
+	}
+}
+
+func TestNegotiateCastClampsBitrate(t *testing.T) {
+	got := negotiateCast(CastParams{FPS: 30, BitrateKB: maxCastBitrateKB * 10, Codec: CastCodecH264})
+	if got.BitrateKB != maxCastBitrateKB {
+		t.Errorf("BitrateKB = %d, want clamp to %d", got.BitrateKB, maxCastBitrateKB)
+	}
+	if got.FPS != 30 || got.Codec != CastCodecH264 {
+		t.Errorf("negotiateCast should preserve valid proposed fields, got %+v", got)
+	}
+}
+
+// encodeCastFrame builds the 4-byte-length-prefixed wire form of a single
+// cast frame.
+func encodeCastFrame(payload []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(payload)))
+	return append(header, payload...)
+}
+
+// dribbleReader returns at most chunkSize bytes per Read, simulating a TCP
+// connection that delivers a frame across many fragmented reads.
+type dribbleReader struct {
+	buf       []byte
+	chunkSize int
+}
+
+func (d *dribbleReader) Read(p []byte) (int, error) {
+	if len(d.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := d.chunkSize
+	if n > len(d.buf) {
+		n = len(d.buf)
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, d.buf[:n])
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func TestReadCastFramesReassemblesFrameSplitAcrossReads(t *testing.T) {
+	payload := make([]byte, 10000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	r := &dribbleReader{buf: encodeCastFrame(payload), chunkSize: 7}
+
+	out := make(chan []byte, 4)
+	readCastFrames(r, out)
+
+	got, ok := <-out
+	if !ok {
+		t.Fatal("readCastFrames produced no frame for a frame fragmented across many small reads")
+	}
+	if string(got) != string(payload) {
+		t.Errorf("reassembled frame has %d bytes, want %d matching bytes", len(got), len(payload))
+	}
+	if _, ok := <-out; ok {
+		t.Error("readCastFrames produced more than one frame for a single-frame stream")
+	}
+}
+
+func TestReadCastFramesHandlesMultipleFramesInOneRead(t *testing.T) {
+	frame1 := []byte("first-frame")
+	frame2 := []byte("second-frame-is-longer")
+	var buf []byte
+	buf = append(buf, encodeCastFrame(frame1)...)
+	buf = append(buf, encodeCastFrame(frame2)...)
+
+	// chunkSize larger than the whole buffer: both frames land in a
+	// single underlying Read call.
+	r := &dribbleReader{buf: buf, chunkSize: len(buf)}
+
+	out := make(chan []byte, 4)
+	readCastFrames(r, out)
+
+	var got [][]byte
+	for f := range out {
+		got = append(got, f)
+	}
+	if len(got) != 2 {
+		t.Fatalf("readCastFrames produced %d frames, want 2", len(got))
+	}
+	if string(got[0]) != string(frame1) || string(got[1]) != string(frame2) {
+		t.Errorf("frames = %q, want [%q %q]", got, frame1, frame2)
+	}
+}
+
+func TestReadCastFramesStopsOnFrameLargerThanMax(t *testing.T) {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, maxCastFrameSize+1)
+	r := &dribbleReader{buf: header, chunkSize: len(header)}
+
+	out := make(chan []byte, 1)
+	done := make(chan struct{})
+	go func() {
+		readCastFrames(r, out)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("readCastFrames did not return for an oversized declared frame length")
+	}
+	if _, ok := <-out; ok {
+		t.Error("readCastFrames should not emit a frame for an oversized declared length")
+	}
+}
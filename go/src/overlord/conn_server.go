@@ -0,0 +1,96 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"net"
+	"sync"
+)
+
+// ConnServer represents a single agent connection accepted by the
+// Overlord socket server: it owns the raw transport, tracks the agent's
+// ConnState, and is the unit that mode-specific handlers (terminal,
+// logcat, cast, resource push, ...) bridge to an HTTP-facing consumer.
+type ConnServer struct {
+	MID   string
+	Mode  int
+	Conn  net.Conn
+	State *ConnStateTracker
+
+	closeOnce sync.Once
+}
+
+// NewConnServer wraps an accepted agent connection, marks it Ready, and
+// records it in the per-mode metrics gauge.
+func NewConnServer(mid string, mode int, conn net.Conn) *ConnServer {
+	cs := &ConnServer{
+		MID:   mid,
+		Mode:  mode,
+		Conn:  conn,
+		State: NewConnStateTracker(),
+	}
+	cs.State.SetState(Ready)
+	ObserveAgentAccept(mode)
+	return cs
+}
+
+// Close tears down the connection, transitions the tracker to Shutdown,
+// and undoes the accept-time metric. Safe to call more than once.
+func (cs *ConnServer) Close() error {
+	var err error
+	cs.closeOnce.Do(func() {
+		cs.State.SetState(Shutdown)
+		ObserveAgentClose(cs.Mode)
+		err = cs.Conn.Close()
+	})
+	return err
+}
+
+// Dispatch runs an RPC against this agent and records its outcome in the
+// overlord_rpc_total metric, mirroring the existing Success/Failed RPC
+// state constants.
+func (cs *ConnServer) Dispatch(rpc func() error) error {
+	err := rpc()
+	if err != nil {
+		ObserveRPC(Failed)
+	} else {
+		ObserveRPC(Success)
+	}
+	return err
+}
+
+// AgentRegistry looks up live ConnServers by MID so HTTP handlers (cast,
+// resource push, forward, ...) can bridge a browser request to the right
+// agent connection.
+type AgentRegistry struct {
+	mu     sync.RWMutex
+	agents map[string]*ConnServer
+}
+
+// Agents is the process-wide registry of connected agents; entries are
+// added with Register and removed with Unregister.
+var Agents = &AgentRegistry{agents: make(map[string]*ConnServer)}
+
+// Register adds cs to the registry, keyed by its MID.
+func (r *AgentRegistry) Register(cs *ConnServer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agents[cs.MID] = cs
+}
+
+// Unregister removes the ConnServer previously stored under mid, if any.
+func (r *AgentRegistry) Unregister(mid string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.agents, mid)
+}
+
+// Lookup returns the ConnServer registered for mid, if connected.
+func (r *AgentRegistry) Lookup(mid string) (*ConnServer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cs, ok := r.agents[mid]
+	return cs, ok
+}
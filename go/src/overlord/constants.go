@@ -24,6 +24,8 @@ const (
 	ModeLogcat
 	ModeFile
 	ModeForward
+	ModeCast
+	ModeResource
 )
 
 // Logcat format
@@ -32,6 +34,12 @@ const (
 	logcatTypeVT100
 )
 
+// Cast payload framing, analogous to logcatType above.
+const (
+	castTypeRaw = iota
+	castTypeMuxed
+)
+
 // RPC states
 const (
 	Success = "success"
@@ -53,6 +61,8 @@ func ModeStr(mode int) string {
 		ModeLogcat:   "Logcat",
 		ModeFile:     "File",
 		ModeForward:  "ModeForward",
+		ModeCast:     "Cast",
+		ModeResource: "Resource",
 	}[mode]
 }
 
@@ -63,13 +73,22 @@ const (
 	dutStatusFailed       = "failed"
 )
 
-// StatusScoreMapping maps the status to an integer for sorting.
+// StatusScoreMapping maps the status to an integer for sorting. DUT
+// execution statuses and agent ConnState names share this namespace so the
+// frontend can sort a single combined list; ConnState entries sort below
+// the DUT statuses since they describe transport health rather than test
+// outcome.
 func StatusScoreMapping(status string) int {
 	return map[string]int{
-		dutStatusIdle:         1,
-		dutStatusRunning:      2,
-		dutStatusDisconnected: 3,
-		dutStatusFailed:       4,
+		dutStatusIdle:             1,
+		dutStatusRunning:          2,
+		dutStatusDisconnected:     3,
+		dutStatusFailed:           4,
+		Idle.String():             5,
+		Connecting.String():       6,
+		Ready.String():            7,
+		TransientFailure.String(): 8,
+		Shutdown.String():         9,
 		// For other status, map would return 0 for int type.
 	}[status]
 }
@@ -0,0 +1,330 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mdnsServiceName is the DNS-SD service type Overlord advertises itself
+// under.
+const mdnsServiceName = "_overlord._tcp.local."
+
+// mdnsMulticastAddr is the well-known mDNS multicast group/port (RFC
+// 6762 section 3).
+const mdnsMulticastAddr = "224.0.0.251:5353"
+
+// legacyDiscovery keeps the old broadcast-on-OverlordLDPort path alive for
+// agents that predate mDNS support, on both the advertise and discover
+// sides.
+var legacyDiscovery = flag.Bool("legacy-discovery", false, "use legacy UDP broadcast discovery instead of mDNS")
+
+// ServerInfo describes a single Overlord instance discovered on the local
+// network.
+type ServerInfo struct {
+	Host       string
+	Version    string
+	HTTPPort   int
+	SocketPort int
+	TLS        bool
+}
+
+func (s ServerInfo) txtFields() []string {
+	return []string{
+		"version=" + s.Version,
+		"http_port=" + strconv.Itoa(s.HTTPPort),
+		"socket_port=" + strconv.Itoa(s.SocketPort),
+		"tls=" + strconv.FormatBool(s.TLS),
+	}
+}
+
+// parseServerInfoTXT fills in Version/HTTPPort/SocketPort/TLS from a set
+// of "key=value" TXT fields. Split out from the network code so the
+// parsing itself is unit-testable.
+func parseServerInfoTXT(fields []string) ServerInfo {
+	var info ServerInfo
+	for _, field := range fields {
+		switch {
+		case strings.HasPrefix(field, "version="):
+			info.Version = field[len("version="):]
+		case strings.HasPrefix(field, "http_port="):
+			info.HTTPPort, _ = strconv.Atoi(field[len("http_port="):])
+		case strings.HasPrefix(field, "socket_port="):
+			info.SocketPort, _ = strconv.Atoi(field[len("socket_port="):])
+		case strings.HasPrefix(field, "tls="):
+			info.TLS, _ = strconv.ParseBool(field[len("tls="):])
+		}
+	}
+	return info
+}
+
+// MDNSAdvertiser is a running mDNS/legacy-broadcast responder for this
+// Overlord instance. Call Shutdown when the Overlord process exits.
+type MDNSAdvertiser struct {
+	conn   net.PacketConn
+	closed chan struct{}
+}
+
+// AdvertiseServer starts responding to discovery probes for this Overlord
+// instance: mDNS PTR queries for mdnsServiceName by default (answered
+// with PTR, SRV and TXT records), or the legacy broadcast probe when
+// -legacy-discovery is set.
+func AdvertiseServer(version string, tls bool) (*MDNSAdvertiser, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "overlord"
+	}
+	info := ServerInfo{Host: host, Version: version, HTTPPort: OverlordHTTPPort, SocketPort: OverlordPort, TLS: tls}
+
+	if *legacyDiscovery {
+		return advertiseLegacy(info)
+	}
+	return advertiseMDNS(info)
+}
+
+// Shutdown stops responding to discovery probes.
+func (a *MDNSAdvertiser) Shutdown() error {
+	close(a.closed)
+	return a.conn.Close()
+}
+
+func advertiseMDNS(info ServerInfo) (*MDNSAdvertiser, error) {
+	addr, err := net.ResolveUDPAddr("udp4", mdnsMulticastAddr)
+	if err != nil {
+		return nil, fmt.Errorf("overlord: resolving mdns multicast addr: %w", err)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("overlord: joining mdns multicast group: %w", err)
+	}
+
+	a := &MDNSAdvertiser{conn: conn, closed: make(chan struct{})}
+	go a.serveMDNS(conn, info)
+	return a, nil
+}
+
+func (a *MDNSAdvertiser) serveMDNS(conn *net.UDPConn, info ServerInfo) {
+	buf := make([]byte, 4096)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		select {
+		case <-a.closed:
+			return
+		default:
+		}
+		if err != nil {
+			return
+		}
+
+		if !isPTRQueryFor(buf[:n], mdnsServiceName) {
+			continue
+		}
+
+		resp := buildMDNSResponse(info)
+		conn.WriteToUDP(resp, src)
+	}
+}
+
+// isPTRQueryFor reports whether msg's single question asks for a PTR
+// record under service.
+func isPTRQueryFor(msg []byte, service string) bool {
+	header, err := decodeDNSHeader(msg)
+	if err != nil || header.QDCount == 0 {
+		return false
+	}
+	name, next, err := decodeName(msg, 12)
+	if err != nil || next+4 > len(msg) {
+		return false
+	}
+	qtype := uint16(msg[next])<<8 | uint16(msg[next+1])
+	return qtype == dnsTypePTR && name == strings.TrimSuffix(service, ".")
+}
+
+// buildMDNSResponse builds a PTR+SRV+TXT answer for info. No A record is
+// included; browsers take the host address from the response's source.
+func buildMDNSResponse(info ServerInfo) []byte {
+	instance := "overlord." + mdnsServiceName
+	header := dnsHeader{Flags: 0x8400, ANCount: 3}.encode() // QR=1, AA=1
+	msg := header
+	msg = encodeRR(msg, strings.TrimSuffix(mdnsServiceName, "."), dnsTypePTR, 120, encodeName(instance))
+	srv := make([]byte, 6)
+	srv[4] = byte(info.SocketPort >> 8)
+	srv[5] = byte(info.SocketPort)
+	msg = encodeRR(msg, strings.TrimSuffix(instance, "."), dnsTypeSRV, 120, append(srv, encodeName(info.Host)...))
+	msg = encodeRR(msg, strings.TrimSuffix(instance, "."), dnsTypeTXT, 120, encodeTXTRData(info.txtFields()))
+	return msg
+}
+
+// DiscoverServers browses the local network for Overlord instances and
+// returns whatever answers within timeout, using mDNS by default or the
+// legacy UDP broadcast protocol when -legacy-discovery is set.
+func DiscoverServers(ctx context.Context, timeout time.Duration) ([]ServerInfo, error) {
+	if *legacyDiscovery {
+		return legacyBroadcastDiscover(ctx, timeout)
+	}
+	return mdnsDiscover(ctx, timeout)
+}
+
+func mdnsDiscover(ctx context.Context, timeout time.Duration) ([]ServerInfo, error) {
+	addr, err := net.ResolveUDPAddr("udp4", mdnsMulticastAddr)
+	if err != nil {
+		return nil, fmt.Errorf("overlord: resolving mdns multicast addr: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("overlord: opening mdns query socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(buildPTRQuestion(mdnsServiceName), addr); err != nil {
+		return nil, fmt.Errorf("overlord: sending mdns query: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetReadDeadline(deadline)
+
+	var servers []ServerInfo
+	buf := make([]byte, 4096)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // timeout (or ctx-derived deadline) reached
+		}
+		if info, ok := parseMDNSResponse(buf[:n], src); ok {
+			servers = append(servers, info)
+		}
+	}
+	return servers, nil
+}
+
+// parseMDNSResponse extracts a ServerInfo from an mDNS response's
+// SRV/TXT answers. Host is always the UDP source address; the SRV
+// target name is not used.
+func parseMDNSResponse(msg []byte, src *net.UDPAddr) (ServerInfo, bool) {
+	rrs, err := parseAnswers(msg)
+	if err != nil {
+		return ServerInfo{}, false
+	}
+
+	info := ServerInfo{Host: src.IP.String()}
+	found := false
+	for _, rr := range rrs {
+		switch rr.Type {
+		case dnsTypeTXT:
+			info = mergeServerInfo(info, parseServerInfoTXT(parseTXTRData(rr.RData)))
+			found = true
+		case dnsTypeSRV:
+			if len(rr.RData) >= 6 {
+				info.SocketPort = int(rr.RData[4])<<8 | int(rr.RData[5])
+			}
+			found = true
+		}
+	}
+	return info, found
+}
+
+func mergeServerInfo(base, overlay ServerInfo) ServerInfo {
+	base.Version = overlay.Version
+	base.HTTPPort = overlay.HTTPPort
+	base.SocketPort = overlay.SocketPort
+	base.TLS = overlay.TLS
+	return base
+}
+
+// --- legacy broadcast discovery, kept for agents predating mDNS support ---
+
+const legacyDiscoverProbe = "OVERLORD_DISCOVER"
+
+func advertiseLegacy(info ServerInfo) (*MDNSAdvertiser, error) {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", OverlordLDPort))
+	if err != nil {
+		return nil, fmt.Errorf("overlord: opening legacy discovery socket: %w", err)
+	}
+
+	a := &MDNSAdvertiser{conn: conn, closed: make(chan struct{})}
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, src, err := conn.ReadFrom(buf)
+			select {
+			case <-a.closed:
+				return
+			default:
+			}
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) != legacyDiscoverProbe {
+				continue
+			}
+			conn.WriteTo([]byte(encodeLegacyReply(info)), src)
+		}
+	}()
+	return a, nil
+}
+
+func legacyBroadcastDiscover(ctx context.Context, timeout time.Duration) ([]ServerInfo, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("overlord: opening legacy discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: OverlordLDPort}
+	if _, err := conn.WriteTo([]byte(legacyDiscoverProbe), broadcast); err != nil {
+		return nil, fmt.Errorf("overlord: sending legacy discovery probe: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetReadDeadline(deadline)
+
+	var servers []ServerInfo
+	buf := make([]byte, 256)
+	for {
+		n, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if info, ok := decodeLegacyReply(string(buf[:n])); ok {
+			if udpAddr, ok := src.(*net.UDPAddr); ok {
+				info.Host = udpAddr.IP.String()
+			}
+			servers = append(servers, info)
+		}
+	}
+	return servers, nil
+}
+
+// encodeLegacyReply/decodeLegacyReply implement the bespoke
+// "OVERLORD:version:http_port:socket_port:tls" text reply the legacy
+// broadcast protocol has always used.
+func encodeLegacyReply(info ServerInfo) string {
+	return fmt.Sprintf("OVERLORD:%s:%d:%d:%v", info.Version, info.HTTPPort, info.SocketPort, info.TLS)
+}
+
+func decodeLegacyReply(s string) (ServerInfo, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 5 || parts[0] != "OVERLORD" {
+		return ServerInfo{}, false
+	}
+	info := ServerInfo{Version: parts[1]}
+	info.HTTPPort, _ = strconv.Atoi(parts[2])
+	info.SocketPort, _ = strconv.Atoi(parts[3])
+	info.TLS, _ = strconv.ParseBool(parts[4])
+	return info, true
+}
@@ -0,0 +1,44 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import "testing"
+
+func TestParseServerInfoTXT(t *testing.T) {
+	info := parseServerInfoTXT([]string{
+		"version=1.2.3",
+		"http_port=9000",
+		"socket_port=4455",
+		"tls=true",
+	})
+	want := ServerInfo{Version: "1.2.3", HTTPPort: 9000, SocketPort: 4455, TLS: true}
+	if info != want {
+		t.Errorf("parseServerInfoTXT() = %+v, want %+v", info, want)
+	}
+}
+
+func TestParseServerInfoTXTIgnoresUnknownFields(t *testing.T) {
+	info := parseServerInfoTXT([]string{"version=1.0", "unknown=field"})
+	if info.Version != "1.0" {
+		t.Errorf("Version = %q, want %q", info.Version, "1.0")
+	}
+}
+
+func TestLegacyReplyRoundTrip(t *testing.T) {
+	info := ServerInfo{Version: "1.2.3", HTTPPort: 9000, SocketPort: 4455, TLS: true}
+	got, ok := decodeLegacyReply(encodeLegacyReply(info))
+	if !ok {
+		t.Fatal("decodeLegacyReply() returned ok=false for a reply this package encoded")
+	}
+	if got.Version != info.Version || got.HTTPPort != info.HTTPPort || got.SocketPort != info.SocketPort || got.TLS != info.TLS {
+		t.Errorf("decodeLegacyReply(encodeLegacyReply(info)) = %+v, want %+v", got, info)
+	}
+}
+
+func TestDecodeLegacyReplyRejectsGarbage(t *testing.T) {
+	if _, ok := decodeLegacyReply("not a legacy reply"); ok {
+		t.Error("decodeLegacyReply() should reject a non-OVERLORD payload")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// This file implements just enough of the DNS wire format (RFC 1035) to
+// send mDNS queries and build/parse mDNS responses for a single service
+// type, without an external dependency. It intentionally never emits
+// compressed names, so the decoder doesn't need to follow name-compression
+// pointers either — a real general-purpose DNS library this is not.
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+
+	dnsClassIN = 1
+)
+
+// encodeName writes name (e.g. "_overlord._tcp.local.") as a sequence of
+// length-prefixed labels terminated by a zero-length root label.
+func encodeName(name string) []byte {
+	var out []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+// decodeName reads a length-prefixed name starting at offset and returns
+// it (without a trailing dot) along with the offset just past it. It
+// returns an error on a compression pointer (0xC0 high bits), which this
+// package never emits itself.
+func decodeName(buf []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(buf) {
+			return "", 0, errors.New("overlord: dns name runs past end of message")
+		}
+		length := int(buf[offset])
+		if length&0xC0 != 0 {
+			return "", 0, errors.New("overlord: compressed dns names are not supported")
+		}
+		offset++
+		if length == 0 {
+			break
+		}
+		if offset+length > len(buf) {
+			return "", 0, errors.New("overlord: dns label runs past end of message")
+		}
+		labels = append(labels, string(buf[offset:offset+length]))
+		offset += length
+	}
+	return strings.Join(labels, "."), offset, nil
+}
+
+// dnsHeader is the fixed 12-byte DNS message header.
+type dnsHeader struct {
+	ID      uint16
+	Flags   uint16
+	QDCount uint16
+	ANCount uint16
+	NSCount uint16
+	ARCount uint16
+}
+
+func (h dnsHeader) encode() []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint16(buf[0:], h.ID)
+	binary.BigEndian.PutUint16(buf[2:], h.Flags)
+	binary.BigEndian.PutUint16(buf[4:], h.QDCount)
+	binary.BigEndian.PutUint16(buf[6:], h.ANCount)
+	binary.BigEndian.PutUint16(buf[8:], h.NSCount)
+	binary.BigEndian.PutUint16(buf[10:], h.ARCount)
+	return buf
+}
+
+func decodeDNSHeader(buf []byte) (dnsHeader, error) {
+	if len(buf) < 12 {
+		return dnsHeader{}, errors.New("overlord: dns message shorter than header")
+	}
+	return dnsHeader{
+		ID:      binary.BigEndian.Uint16(buf[0:]),
+		Flags:   binary.BigEndian.Uint16(buf[2:]),
+		QDCount: binary.BigEndian.Uint16(buf[4:]),
+		ANCount: binary.BigEndian.Uint16(buf[6:]),
+		NSCount: binary.BigEndian.Uint16(buf[8:]),
+		ARCount: binary.BigEndian.Uint16(buf[10:]),
+	}, nil
+}
+
+// dnsRR is a decoded resource record from an mDNS response's answer
+// section.
+type dnsRR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	RData []byte
+}
+
+// buildPTRQuestion builds a one-question mDNS query message asking for
+// PTR records under service (e.g. "_overlord._tcp.local.").
+func buildPTRQuestion(service string) []byte {
+	header := dnsHeader{QDCount: 1}.encode()
+	question := append(encodeName(service), 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(question[len(question)-4:], dnsTypePTR)
+	binary.BigEndian.PutUint16(question[len(question)-2:], dnsClassIN)
+	return append(header, question...)
+}
+
+// encodeRR appends one resource record (name/type/class/ttl/rdata) to buf.
+func encodeRR(buf []byte, name string, typ uint16, ttl uint32, rdata []byte) []byte {
+	buf = append(buf, encodeName(name)...)
+	tmp := make([]byte, 10)
+	binary.BigEndian.PutUint16(tmp[0:], typ)
+	binary.BigEndian.PutUint16(tmp[2:], dnsClassIN)
+	binary.BigEndian.PutUint32(tmp[4:], ttl)
+	binary.BigEndian.PutUint16(tmp[8:], uint16(len(rdata)))
+	buf = append(buf, tmp...)
+	return append(buf, rdata...)
+}
+
+// parseAnswers walks the answer section of an mDNS message and returns
+// each record decoded. It skips the question section entirely, since
+// mDNS responses mirror the question back and we don't need it.
+func parseAnswers(buf []byte) ([]dnsRR, error) {
+	header, err := decodeDNSHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 12
+	for i := 0; i < int(header.QDCount); i++ {
+		_, next, err := decodeName(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4 // qtype + qclass
+	}
+
+	var rrs []dnsRR
+	total := int(header.ANCount) + int(header.NSCount) + int(header.ARCount)
+	for i := 0; i < total; i++ {
+		name, next, err := decodeName(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		if offset+10 > len(buf) {
+			return nil, errors.New("overlord: dns rr header runs past end of message")
+		}
+		typ := binary.BigEndian.Uint16(buf[offset:])
+		class := binary.BigEndian.Uint16(buf[offset+2:])
+		ttl := binary.BigEndian.Uint32(buf[offset+4:])
+		rdlength := int(binary.BigEndian.Uint16(buf[offset+8:]))
+		offset += 10
+		if offset+rdlength > len(buf) {
+			return nil, errors.New("overlord: dns rdata runs past end of message")
+		}
+		rrs = append(rrs, dnsRR{Name: name, Type: typ, Class: class, TTL: ttl, RData: buf[offset : offset+rdlength]})
+		offset += rdlength
+	}
+	return rrs, nil
+}
+
+// parseTXTRData splits a TXT record's rdata (a sequence of
+// length-prefixed strings) into its "key=value" fields.
+func parseTXTRData(rdata []byte) []string {
+	var fields []string
+	for i := 0; i < len(rdata); {
+		length := int(rdata[i])
+		i++
+		if i+length > len(rdata) {
+			break
+		}
+		fields = append(fields, string(rdata[i:i+length]))
+		i += length
+	}
+	return fields
+}
+
+// encodeTXTRData is the inverse of parseTXTRData.
+func encodeTXTRData(fields []string) []byte {
+	var out []byte
+	for _, f := range fields {
+		out = append(out, byte(len(f)))
+		out = append(out, f...)
+	}
+	return out
+}
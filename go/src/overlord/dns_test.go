@@ -0,0 +1,81 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeNameRoundTrip(t *testing.T) {
+	name := "_overlord._tcp.local"
+	encoded := encodeName(name)
+
+	got, next, err := decodeName(encoded, 0)
+	if err != nil {
+		t.Fatalf("decodeName() error = %v", err)
+	}
+	if got != name {
+		t.Errorf("decodeName() = %q, want %q", got, name)
+	}
+	if next != len(encoded) {
+		t.Errorf("decodeName() consumed %d bytes, want %d", next, len(encoded))
+	}
+}
+
+func TestDecodeNameRejectsCompressionPointer(t *testing.T) {
+	buf := []byte{0xC0, 0x0C}
+	if _, _, err := decodeName(buf, 0); err == nil {
+		t.Fatal("decodeName() should reject a compression pointer")
+	}
+}
+
+func TestTXTRDataRoundTrip(t *testing.T) {
+	fields := []string{"version=1.0", "http_port=9000", "tls=false"}
+	got := parseTXTRData(encodeTXTRData(fields))
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("parseTXTRData(encodeTXTRData(fields)) = %v, want %v", got, fields)
+	}
+}
+
+func TestIsPTRQueryFor(t *testing.T) {
+	query := buildPTRQuestion(mdnsServiceName)
+	if !isPTRQueryFor(query, mdnsServiceName) {
+		t.Error("isPTRQueryFor() = false for a query this package built for the same service")
+	}
+	if isPTRQueryFor(query, "_other._tcp.local.") {
+		t.Error("isPTRQueryFor() = true for a mismatched service name")
+	}
+}
+
+func TestBuildAndParseMDNSResponse(t *testing.T) {
+	info := ServerInfo{Host: "dut-42", Version: "1.2.3", HTTPPort: 9000, SocketPort: 4455, TLS: true}
+	msg := buildMDNSResponse(info)
+
+	rrs, err := parseAnswers(msg)
+	if err != nil {
+		t.Fatalf("parseAnswers() error = %v", err)
+	}
+	if len(rrs) != 3 {
+		t.Fatalf("parseAnswers() returned %d records, want 3", len(rrs))
+	}
+
+	var gotTXT ServerInfo
+	var gotSocketPort int
+	for _, rr := range rrs {
+		switch rr.Type {
+		case dnsTypeTXT:
+			gotTXT = parseServerInfoTXT(parseTXTRData(rr.RData))
+		case dnsTypeSRV:
+			gotSocketPort = int(rr.RData[4])<<8 | int(rr.RData[5])
+		}
+	}
+	if gotTXT.Version != info.Version || gotTXT.SocketPort != info.SocketPort || gotTXT.TLS != info.TLS {
+		t.Errorf("TXT round trip = %+v, want version/socket_port/tls matching %+v", gotTXT, info)
+	}
+	if gotSocketPort != info.SocketPort {
+		t.Errorf("SRV port = %d, want %d (the agent socket port, not HTTPPort)", gotSocketPort, info.SocketPort)
+	}
+}
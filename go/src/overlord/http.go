@@ -0,0 +1,69 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// httpAuthUser/httpAuthPass gate -metrics-auth (and any other handler that
+// opts into basicAuthMiddleware) behind a single shared credential pair.
+// Left empty by default, same as -metrics-auth itself: an operator must set
+// all three before anything is actually protected.
+var (
+	httpAuthUser = flag.String("http-user", "", "username required when -metrics-auth is set")
+	httpAuthPass = flag.String("http-pass", "", "password required when -metrics-auth is set")
+)
+
+// NewHTTPMux builds the ServeMux mounted on OverlordHTTPPort, wiring up
+// every mode-specific API route alongside the existing web UI/static
+// handlers the caller registers separately.
+func NewHTTPMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/agent/", handleAgentAPI)
+	RegisterMetricsHandler(mux, basicAuthMiddleware)
+	return mux
+}
+
+// basicAuthMiddleware enforces httpAuthUser/httpAuthPass via HTTP Basic
+// Auth, comparing in constant time so a timing side channel can't be used
+// to guess the credentials byte by byte.
+func basicAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(*httpAuthUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(*httpAuthPass)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="overlord"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAgentAPI dispatches /api/agent/:mid/<action> requests. It's a
+// plain ServeMux handler rather than a router dependency, consistent with
+// the rest of this package's light footprint.
+func handleAgentAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/agent/")
+	mid, action, ok := strings.Cut(rest, "/")
+	if !ok || mid == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch action {
+	case "cast":
+		HandleCastRequest(w, r, mid)
+	case "push-resource":
+		HandleResourceRequest(w, r, mid)
+	default:
+		http.NotFound(w, r)
+	}
+}
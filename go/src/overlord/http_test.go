@@ -0,0 +1,73 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuthMiddlewareRejectsMissingAndWrongCredentials(t *testing.T) {
+	oldUser, oldPass := *httpAuthUser, *httpAuthPass
+	*httpAuthUser, *httpAuthPass = "ted", "s3cret"
+	defer func() { *httpAuthUser, *httpAuthPass = oldUser, oldPass }()
+
+	handler := basicAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		wantStatus int
+	}{
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+		{"wrong password", "ted", "wrong", true, http.StatusUnauthorized},
+		{"correct credentials", "ted", "s3cret", true, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if c.setAuth {
+				req.SetBasicAuth(c.user, c.pass)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != c.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, c.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewHTTPMuxGatesMetricsWhenAuthEnabled(t *testing.T) {
+	oldAuth := *metricsAuth
+	oldUser, oldPass := *httpAuthUser, *httpAuthPass
+	*metricsAuth = true
+	*httpAuthUser, *httpAuthPass = "ted", "s3cret"
+	defer func() {
+		*metricsAuth = oldAuth
+		*httpAuthUser, *httpAuthPass = oldUser, oldPass
+	}()
+
+	mux := NewHTTPMux()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated /metrics status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("ted", "s3cret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("authenticated /metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
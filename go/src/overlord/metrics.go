@@ -0,0 +1,213 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsAuth gates /metrics behind the same basic-auth credentials as the
+// rest of the HTTP server. Left off by default so it can be scraped
+// unauthenticated from a private network.
+var metricsAuth = flag.Bool("metrics-auth", false, "require basic auth on the /metrics endpoint")
+
+// This package has no go.mod/vendor story (it lives under a plain GOPATH
+// src tree), so rather than add an unvendored github.com/prometheus/...
+// import that can't actually be fetched, /metrics is served by this small
+// hand-rolled registry. It only implements what ObserveAgentAccept and
+// friends below need: labeled gauges/counters and one fixed-bucket
+// histogram, rendered in the standard Prometheus text exposition format.
+
+type labeledGauge struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	label  string
+	values map[string]float64
+}
+
+func newLabeledGauge(name, help, label string) *labeledGauge {
+	return &labeledGauge{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (g *labeledGauge) add(value string, delta float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[value] += delta
+}
+
+func (g *labeledGauge) writeTo(w *strings.Builder) {
+	writeHeader(w, g.name, g.help, "gauge")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, value := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", g.name, g.label, value, formatFloat(g.values[value]))
+	}
+}
+
+type labeledCounter struct {
+	labeledGauge
+}
+
+func newLabeledCounter(name, help, label string) *labeledCounter {
+	return &labeledCounter{labeledGauge: *newLabeledGauge(name, help, label)}
+}
+
+func (c *labeledCounter) inc(value string) { c.add(value, 1) }
+
+func (c *labeledCounter) writeTo(w *strings.Builder) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, value := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", c.name, c.label, value, formatFloat(c.values[value]))
+	}
+}
+
+// histogram is a fixed-bucket cumulative histogram, matching Prometheus's
+// "le" bucket convention closely enough to scrape.
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64 // upper bounds, ascending
+	counts  []uint64  // counts[i] = observations <= buckets[i]
+	sum     float64
+	total   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += v
+	h.total++
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *histogram) writeTo(w *strings.Builder) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.total)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.total)
+}
+
+func writeHeader(w *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	if v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// linearBuckets mirrors prometheus.LinearBuckets: count buckets starting
+// at start and increasing by width each step.
+func linearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+var (
+	agentsByMode      = newLabeledGauge("overlord_agents_by_mode", "Number of connected agents, partitioned by ConnServer mode.", "mode")
+	agentsByDUTStatus = newLabeledGauge("overlord_agents_by_dut_status", "Number of connected agents, partitioned by DUT status.", "status")
+	rpcTotal          = newLabeledCounter("overlord_rpc_total", "RPC calls dispatched, partitioned by result (success/failed).", "result")
+	pingRTTSeconds    = newHistogram("overlord_ping_rtt_seconds", "Round-trip time of agent pings.", linearBuckets(0, float64(pingTimeout)/10, 10))
+	bytesTransferred  = newLabeledCounter("overlord_bytes_transferred_total", "Bytes transferred over file/forward/logcat/cast/resource connections.", "mode")
+)
+
+// ObserveAgentAccept records a newly-accepted agent connection in the
+// per-mode gauge. Called from ConnServer's constructor.
+func ObserveAgentAccept(mode int) {
+	agentsByMode.add(ModeStr(mode), 1)
+}
+
+// ObserveAgentClose undoes ObserveAgentAccept when a ConnServer is torn
+// down.
+func ObserveAgentClose(mode int) {
+	agentsByMode.add(ModeStr(mode), -1)
+}
+
+// ObserveDUTStatus updates the gauge backing a single DUT's status label,
+// moving the count from the previous status to the new one.
+func ObserveDUTStatus(prev, next string) {
+	if prev != "" {
+		agentsByDUTStatus.add(prev, -1)
+	}
+	agentsByDUTStatus.add(next, 1)
+}
+
+// ObserveRPC records the outcome of a dispatched RPC, result being one of
+// the Success/Failed constants. Called from ConnServer.Dispatch.
+func ObserveRPC(result string) {
+	rpcTotal.inc(result)
+}
+
+// ObservePingRTT records a ping round-trip in seconds. Called from
+// ReconnectLoop.pingUntilFailure.
+func ObservePingRTT(seconds float64) {
+	pingRTTSeconds.observe(seconds)
+}
+
+// ObserveBytesTransferred adds n bytes to the counter for the given mode
+// (ModeFile, ModeForward, ModeLogcat, ModeCast, or ModeResource).
+func ObserveBytesTransferred(mode int, n int) {
+	bytesTransferred.add(ModeStr(mode), float64(n))
+}
+
+// RegisterMetricsHandler mounts /metrics on mux, gating it behind
+// basicAuth when -metrics-auth requests it.
+func RegisterMetricsHandler(mux *http.ServeMux, basicAuth func(http.Handler) http.Handler) {
+	var handler http.Handler = http.HandlerFunc(serveMetrics)
+	if *metricsAuth && basicAuth != nil {
+		handler = basicAuth(handler)
+	}
+	mux.Handle("/metrics", handler)
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	agentsByMode.writeTo(&b)
+	agentsByDUTStatus.writeTo(&b)
+	rpcTotal.writeTo(&b)
+	pingRTTSeconds.writeTo(&b)
+	bytesTransferred.writeTo(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
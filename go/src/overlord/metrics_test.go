@@ -0,0 +1,64 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabeledGaugeWriteTo(t *testing.T) {
+	g := newLabeledGauge("test_gauge", "a test gauge", "label")
+	g.add("x", 2)
+	g.add("x", -1)
+	g.add("y", 5)
+
+	var b strings.Builder
+	g.writeTo(&b)
+	out := b.String()
+
+	if !strings.Contains(out, `test_gauge{label="x"} 1`) {
+		t.Errorf("output missing x=1 line, got:\n%s", out)
+	}
+	if !strings.Contains(out, `test_gauge{label="y"} 5`) {
+		t.Errorf("output missing y=5 line, got:\n%s", out)
+	}
+	if !strings.Contains(out, "# TYPE test_gauge gauge") {
+		t.Errorf("output missing TYPE header, got:\n%s", out)
+	}
+}
+
+func TestHistogramWriteTo(t *testing.T) {
+	h := newHistogram("test_hist", "a test histogram", []float64{1, 2, 5})
+	h.observe(0.5)
+	h.observe(1.5)
+	h.observe(10)
+
+	var b strings.Builder
+	h.writeTo(&b)
+	out := b.String()
+
+	if !strings.Contains(out, `test_hist_bucket{le="1"} 1`) {
+		t.Errorf("bucket le=1 should count the 0.5 observation, got:\n%s", out)
+	}
+	if !strings.Contains(out, `test_hist_bucket{le="+Inf"} 3`) {
+		t.Errorf("+Inf bucket should count all 3 observations, got:\n%s", out)
+	}
+	if !strings.Contains(out, "test_hist_count 3") {
+		t.Errorf("missing total count, got:\n%s", out)
+	}
+}
+
+func TestConnServerDispatchRecordsRPCOutcome(t *testing.T) {
+	cs := &ConnServer{Mode: ModeShell, State: NewConnStateTracker()}
+
+	before := rpcTotal.values[Success]
+	if err := cs.Dispatch(func() error { return nil }); err != nil {
+		t.Fatalf("Dispatch returned unexpected error: %v", err)
+	}
+	if got := rpcTotal.values[Success]; got != before+1 {
+		t.Errorf("overlord_rpc_total{result=success} = %v, want %v", got, before+1)
+	}
+}
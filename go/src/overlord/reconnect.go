@@ -0,0 +1,120 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Dialer opens the agent's underlying transport to the Overlord server.
+// Swappable in tests so ReconnectLoop doesn't need a live socket.
+type Dialer func() (net.Conn, error)
+
+// Pinger sends a single ping over an established connection and reports
+// whether the agent is still alive. Swappable in tests for the same
+// reason as Dialer.
+type Pinger func(net.Conn) error
+
+// ReconnectLoop replaces the ad-hoc reconnect loops agents used to run
+// with one driven by the ConnState machine: Connecting while dialing,
+// Ready once connected, TransientFailure only after a ping actually
+// times out, then back to Connecting once the backoff elapses.
+type ReconnectLoop struct {
+	State *ConnStateTracker
+	Dial  Dialer
+	Ping  Pinger
+
+	// pingInterval defaults to pingTimeout seconds; overridable in tests.
+	pingInterval time.Duration
+
+	// onBackoff, if set, is called with every backoff duration Run computes
+	// (on a failed dial or a failed ping), so tests can assert it grows on
+	// repeated failures and resets after a successful reconnect without
+	// needing to inflate pingInterval/backoffBase to make the effect
+	// observable on a wall clock.
+	onBackoff func(time.Duration)
+}
+
+// NewReconnectLoop builds a ReconnectLoop starting in the Idle state.
+func NewReconnectLoop(dial Dialer, ping Pinger) *ReconnectLoop {
+	return &ReconnectLoop{
+		State:        NewConnStateTracker(),
+		Dial:         dial,
+		Ping:         ping,
+		pingInterval: pingTimeout * time.Second,
+	}
+}
+
+// Run drives the dial/ping/backoff cycle until ctx is canceled, at which
+// point the tracker transitions to Shutdown and Run returns.
+func (r *ReconnectLoop) Run(ctx context.Context) {
+	var backoff time.Duration
+	for ctx.Err() == nil {
+		r.State.SetState(Connecting)
+		conn, err := r.Dial()
+		if err != nil {
+			backoff = nextBackoff(backoff)
+			if r.onBackoff != nil {
+				r.onBackoff(backoff)
+			}
+			if !sleepCtx(ctx, backoff) {
+				break
+			}
+			continue
+		}
+
+		r.State.SetState(Ready)
+		backoff = 0
+		r.pingUntilFailure(ctx, conn)
+		conn.Close()
+
+		if ctx.Err() != nil {
+			break
+		}
+		r.State.SetState(TransientFailure)
+		backoff = nextBackoff(backoff)
+		if r.onBackoff != nil {
+			r.onBackoff(backoff)
+		}
+		if !sleepCtx(ctx, backoff) {
+			break
+		}
+	}
+	r.State.SetState(Shutdown)
+}
+
+// pingUntilFailure pings conn on pingInterval until Ping returns an error
+// (the agent stops responding) or ctx is canceled.
+func (r *ReconnectLoop) pingUntilFailure(ctx context.Context, conn net.Conn) {
+	ticker := time.NewTicker(r.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if err := r.Ping(conn); err != nil {
+				return
+			}
+			ObservePingRTT(time.Since(start).Seconds())
+		}
+	}
+}
+
+// sleepCtx waits for d or ctx cancellation, whichever comes first,
+// reporting whether the sleep ran to completion.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReconnectLoopRunDrivesStateMachine exercises Run end to end: two
+// failed dials (exercising backoff growth), a successful connect, a failed
+// ping (exercising the TransientFailure path and backoff reset), then a
+// second successful connect before the test cancels ctx.
+func TestReconnectLoopRunDrivesStateMachine(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	var mu sync.Mutex
+	dialCalls := 0
+	pingCalls := 0
+
+	loop := NewReconnectLoop(
+		func() (net.Conn, error) {
+			mu.Lock()
+			dialCalls++
+			n := dialCalls
+			mu.Unlock()
+			if n <= 2 {
+				return nil, errors.New("connection refused")
+			}
+			// Give the state-watching goroutine below a chance to observe
+			// the Connecting state before this (instant, in-memory) dial
+			// would otherwise flip straight to Ready.
+			time.Sleep(50 * time.Millisecond)
+			return client, nil
+		},
+		func(net.Conn) error {
+			mu.Lock()
+			pingCalls++
+			n := pingCalls
+			mu.Unlock()
+			if n == 1 {
+				return errors.New("ping timed out")
+			}
+			return nil
+		},
+	)
+	loop.pingInterval = time.Millisecond
+
+	var backoffs []time.Duration
+	loop.onBackoff = func(d time.Duration) {
+		mu.Lock()
+		backoffs = append(backoffs, d)
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var states []ConnState
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		last := Idle
+		for {
+			if !loop.State.WaitForStateChange(ctx, last) {
+				return
+			}
+			cur := loop.State.State()
+			states = append(states, cur)
+			last = cur
+			// Connecting x3 (two failed dials, one successful one), Ready,
+			// TransientFailure (the failed ping), Connecting: the sequence
+			// through one full reconnect cycle.
+			if len(states) >= 6 {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	runDone := make(chan struct{})
+	go func() {
+		loop.Run(ctx)
+		close(runDone)
+	}()
+
+	select {
+	case <-runDone:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ReconnectLoop.Run did not return after ctx cancellation")
+	}
+	<-watcherDone
+
+	wantStates := []ConnState{Connecting, Connecting, Connecting, Ready, TransientFailure, Connecting}
+	if len(states) != len(wantStates) {
+		t.Fatalf("states = %v, want %v", states, wantStates)
+	}
+	for i, want := range wantStates {
+		if states[i] != want {
+			t.Errorf("states[%d] = %v, want %v (full sequence %v)", i, states[i], want, states)
+		}
+	}
+	if got := loop.State.State(); got != Shutdown {
+		t.Errorf("State() after Run returns = %v, want %v", got, Shutdown)
+	}
+
+	if len(backoffs) != 3 {
+		t.Fatalf("onBackoff called %d times, want 3 (two dial failures, one ping failure), got %v", len(backoffs), backoffs)
+	}
+	if backoffs[1] <= backoffs[0] {
+		t.Errorf("backoff should grow across consecutive dial failures: %v then %v", backoffs[0], backoffs[1])
+	}
+	if backoffs[2] >= backoffs[1] {
+		t.Errorf("backoff should reset after a successful reconnect, not keep growing: %v then %v", backoffs[1], backoffs[2])
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ResourceManifest is the first frame sent over a ModeResource connection,
+// describing the archive being pushed so the agent can decide whether it
+// already has a partial copy to resume. Verify is carried here, rather
+// than out of band, because it's the agent applying the resource that
+// needs to act on it.
+type ResourceManifest struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int    `json:"chunk_size"`
+	Verify    bool   `json:"verify"`
+}
+
+// ResourceResumeOffset is the agent's reply to a ResourceManifest: the
+// size of the file with this Name that it already has on disk. The
+// existing bytes are not checked against SHA256. A fresh transfer replies
+// with 0.
+type ResourceResumeOffset struct {
+	Offset int64 `json:"offset"`
+}
+
+// ResourceChunk tags a slice of the archive with its sequence number,
+// counted from offset 0 in ChunkSize units. ReceiveResource writes chunks
+// in arrival order and does not use Seq.
+type ResourceChunk struct {
+	Seq  int    `json:"seq"`
+	Data []byte `json:"data"`
+}
+
+// ResourceStage identifies which part of a resource push a
+// ResourceProgress event describes.
+type ResourceStage string
+
+const (
+	ResourceStageTransfer ResourceStage = "transfer"
+	ResourceStageApply    ResourceStage = "apply"
+	ResourceStageVerify   ResourceStage = "verify"
+)
+
+// ResourceProgress reports one stage of a push for rendering as a
+// progress bar. The agent sends apply and verify events over the
+// connection; transfer events are produced by PushResource itself, once
+// per chunk, and only reach its onProgress callback.
+type ResourceProgress struct {
+	Stage   ResourceStage `json:"stage"`
+	Sent    int64         `json:"sent"`
+	Total   int64         `json:"total"`
+	Percent float64       `json:"percent"`
+}
+
+// resourceStatus is the final frame on a ModeResource connection, reusing
+// the existing Success/Failed RPC constants.
+type resourceStatus struct {
+	Status string `json:"status"`
+}
+
+// resourceChunkSize is the default chunk size used when a manifest doesn't
+// override it.
+const resourceChunkSize = 1 << 20 // 1 MiB
+
+// PushResourceOptions holds the arguments of the ghost client's
+// `push-resource <mid> <file>` subcommand: Resume skips straight to the
+// offset the agent reports already having, Verify re-hashes the target
+// after apply instead of trusting the transfer alone.
+type PushResourceOptions struct {
+	MID    string
+	File   string
+	Resume bool
+	Verify bool
+}
+
+// PushResource drives the client side of the ModeResource protocol over
+// conn: it sends the manifest, honors the agent's reported resume offset
+// when resume is true, streams the remaining chunks of src, then drains
+// apply/verify ResourceProgress events until the agent's final
+// Success/Failed status, invoking onProgress (if non-nil) for every
+// transfer/apply/verify event along the way.
+func PushResource(conn io.ReadWriter, src io.ReaderAt, manifest ResourceManifest, resume bool, onProgress func(ResourceProgress)) error {
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	if manifest.ChunkSize <= 0 {
+		manifest.ChunkSize = resourceChunkSize
+	}
+	if err := enc.Encode(manifest); err != nil {
+		return fmt.Errorf("overlord: sending resource manifest: %w", err)
+	}
+
+	var resumeOffset ResourceResumeOffset
+	if err := dec.Decode(&resumeOffset); err != nil {
+		return fmt.Errorf("overlord: reading resume offset: %w", err)
+	}
+
+	offset := int64(0)
+	if resume {
+		offset = resumeOffset.Offset
+	}
+
+	seq := int(offset / int64(manifest.ChunkSize))
+	buf := make([]byte, manifest.ChunkSize)
+	for offset < manifest.Size {
+		n, err := src.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("overlord: reading chunk %d: %w", seq, err)
+		}
+		if n == 0 {
+			break
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if err := enc.Encode(ResourceChunk{Seq: seq, Data: data}); err != nil {
+			return fmt.Errorf("overlord: sending chunk %d: %w", seq, err)
+		}
+		ObserveBytesTransferred(ModeResource, n)
+
+		offset += int64(n)
+		seq++
+		if onProgress != nil {
+			onProgress(ResourceProgress{
+				Stage:   ResourceStageTransfer,
+				Sent:    offset,
+				Total:   manifest.Size,
+				Percent: resourcePercent(offset, manifest.Size),
+			})
+		}
+	}
+
+	return drainResourceCompletion(dec, onProgress)
+}
+
+// drainResourceCompletion reads apply/verify ResourceProgress events
+// emitted after the last chunk, forwarding each to onProgress, until the
+// agent sends its final resourceStatus frame.
+func drainResourceCompletion(dec *json.Decoder, onProgress func(ResourceProgress)) error {
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("overlord: reading resource completion: %w", err)
+		}
+
+		var progress ResourceProgress
+		if err := json.Unmarshal(raw, &progress); err == nil && progress.Stage != "" {
+			if onProgress != nil {
+				onProgress(progress)
+			}
+			continue
+		}
+
+		var status resourceStatus
+		if err := json.Unmarshal(raw, &status); err != nil || status.Status == "" {
+			return fmt.Errorf("overlord: unexpected resource frame: %s", raw)
+		}
+		if status.Status != Success {
+			return fmt.Errorf("overlord: resource push failed: %s", status.Status)
+		}
+		return nil
+	}
+}
+
+// resourcePercent is split out so the percentage math, including the
+// zero-total case, is independently testable.
+func resourcePercent(sent, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(sent) / float64(total) * 100
+}
+
+// HandleResourceRequest upgrades the HTTP request to a WebSocket and
+// bridges it, byte for byte, to the ModeResource agent connection
+// registered under mid. Both ends already speak the same
+// manifest/chunk/progress/status protocol (PushResource on one side,
+// ReceiveResource on the other), so the server's job here is purely to
+// relay; unlike cast it doesn't need to parse or throttle anything.
+func HandleResourceRequest(w http.ResponseWriter, r *http.Request, mid string) {
+	cs, ok := Agents.Lookup(mid)
+	if !ok || cs.Mode != ModeResource {
+		http.NotFound(w, r)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	stream := newWSByteStream(ws)
+	agentDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(cs.Conn, stream)
+		agentDone <- err
+	}()
+
+	n, err := io.Copy(stream, cs.Conn)
+	ObserveBytesTransferred(ModeResource, int(n))
+	if err != nil {
+		return
+	}
+	<-agentDone
+}
+
+// PushResourceOverWS dials the Overlord server's push-resource WebSocket
+// bridge for mid and streams src to it via PushResource. This is how the
+// ghost CLI reaches an agent: through the server's HTTP/WS front door
+// (like every other mode), never by dialing the agent's socket directly.
+func PushResourceOverWS(serverAddr, mid string, src io.ReaderAt, manifest ResourceManifest, resume bool, onProgress func(ResourceProgress)) error {
+	ws, err := dialWebSocket(serverAddr, "/api/agent/"+mid+"/push-resource")
+	if err != nil {
+		return fmt.Errorf("overlord: dialing push-resource endpoint: %w", err)
+	}
+	defer ws.Close()
+
+	return PushResource(newWSByteStream(ws), src, manifest, resume, onProgress)
+}
+
+// ReceiveResource is the agent side of the ModeResource protocol: it reads
+// the manifest, reports how much of the file (by name) it already has so
+// PushResource can resume, writes incoming chunks to destDir, and -- when
+// the manifest asks for it -- re-hashes the result before reporting
+// Success/Failed.
+func ReceiveResource(conn io.ReadWriter, destDir string) error {
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	var manifest ResourceManifest
+	if err := dec.Decode(&manifest); err != nil {
+		return fmt.Errorf("overlord: reading resource manifest: %w", err)
+	}
+
+	path := filepath.Join(destDir, manifest.Name)
+	offset, err := resumableOffset(path, manifest)
+	if err != nil {
+		return fmt.Errorf("overlord: checking existing %s: %w", path, err)
+	}
+	if err := enc.Encode(ResourceResumeOffset{Offset: offset}); err != nil {
+		return fmt.Errorf("overlord: sending resume offset: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("overlord: opening %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("overlord: seeking %s: %w", path, err)
+	}
+
+	// PushResource streams every chunk without reading anything back until
+	// the whole transfer is done (it only starts reading at
+	// drainResourceCompletion), so this loop must not write anything to
+	// conn either -- doing so would deadlock a lockstep transport like
+	// net.Pipe once both sides are blocked on a Write with nobody reading.
+	received := offset
+	for received < manifest.Size {
+		var chunk ResourceChunk
+		if err := dec.Decode(&chunk); err != nil {
+			return fmt.Errorf("overlord: reading chunk: %w", err)
+		}
+		if _, err := f.Write(chunk.Data); err != nil {
+			return fmt.Errorf("overlord: writing %s: %w", path, err)
+		}
+		received += int64(len(chunk.Data))
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("overlord: closing %s: %w", path, err)
+	}
+
+	enc.Encode(ResourceProgress{Stage: ResourceStageApply, Sent: manifest.Size, Total: manifest.Size, Percent: 100})
+	// Applying the resource (flashing, extracting, installing, ...) is
+	// specific to what's being pushed and lives outside this protocol;
+	// this is the hook point where that step would run.
+
+	status := Success
+	if manifest.Verify {
+		enc.Encode(ResourceProgress{Stage: ResourceStageVerify, Sent: manifest.Size, Total: manifest.Size, Percent: 100})
+		sum, err := sha256File(path)
+		if err != nil || sum != manifest.SHA256 {
+			status = Failed
+		}
+	}
+	return enc.Encode(resourceStatus{Status: status})
+}
+
+// resumableOffset reports how much of manifest's target this agent already
+// has on disk. A missing file (or one larger than the new manifest, which
+// can only be a stale leftover) starts from 0.
+func resumableOffset(path string, manifest ResourceManifest) (int64, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > manifest.Size {
+		return 0, nil
+	}
+	return info.Size(), nil
+}
+
+// sha256File hashes the file at path, for ReceiveResource's post-apply
+// verification.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
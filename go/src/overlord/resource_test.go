@@ -0,0 +1,263 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResourcePercent(t *testing.T) {
+	cases := []struct {
+		sent, total int64
+		want        float64
+	}{
+		{0, 100, 0},
+		{50, 100, 50},
+		{100, 100, 100},
+		{10, 0, 0},
+	}
+	for _, c := range cases {
+		if got := resourcePercent(c.sent, c.total); got != c.want {
+			t.Errorf("resourcePercent(%d, %d) = %v, want %v", c.sent, c.total, got, c.want)
+		}
+	}
+}
+
+// fakeAgent stands in for the agent side of a ModeResource session: it
+// replies with a canned resume offset, drains chunks until it has seen
+// the whole file, then emits one apply progress event and a final status.
+func fakeAgent(t *testing.T, conn net.Conn, resumeOffset int64, finalStatus string) []byte {
+	t.Helper()
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	var manifest ResourceManifest
+	if err := dec.Decode(&manifest); err != nil {
+		t.Errorf("fakeAgent: decoding manifest: %v", err)
+		return nil
+	}
+	if err := enc.Encode(ResourceResumeOffset{Offset: resumeOffset}); err != nil {
+		t.Errorf("fakeAgent: encoding resume offset: %v", err)
+		return nil
+	}
+
+	var received bytes.Buffer
+	for int64(received.Len())+resumeOffset < manifest.Size {
+		var chunk ResourceChunk
+		if err := dec.Decode(&chunk); err != nil {
+			t.Errorf("fakeAgent: decoding chunk: %v", err)
+			return nil
+		}
+		received.Write(chunk.Data)
+	}
+
+	enc.Encode(ResourceProgress{Stage: ResourceStageApply, Sent: manifest.Size, Total: manifest.Size, Percent: 100})
+	enc.Encode(resourceStatus{Status: finalStatus})
+	return received.Bytes()
+}
+
+func TestPushResourceFullTransfer(t *testing.T) {
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	payload := bytes.Repeat([]byte("resourcedata"), 1000)
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 64}
+
+	received := make(chan []byte, 1)
+	go func() { received <- fakeAgent(t, agent, 0, Success) }()
+
+	var stages []ResourceStage
+	err := PushResource(client, bytes.NewReader(payload), manifest, false, func(p ResourceProgress) {
+		stages = append(stages, p.Stage)
+	})
+	if err != nil {
+		t.Fatalf("PushResource() error = %v", err)
+	}
+	if got := <-received; !bytes.Equal(got, payload) {
+		t.Errorf("agent received %d bytes, want %d matching payload", len(got), len(payload))
+	}
+	if len(stages) == 0 || stages[len(stages)-1] != ResourceStageApply {
+		t.Errorf("expected a trailing apply progress event, got %v", stages)
+	}
+}
+
+func TestPushResourceResumeSkipsAlreadySentBytes(t *testing.T) {
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	payload := bytes.Repeat([]byte("x"), 500)
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 64}
+	const resumeOffset = 320
+
+	received := make(chan []byte, 1)
+	go func() { received <- fakeAgent(t, agent, resumeOffset, Success) }()
+
+	if err := PushResource(client, bytes.NewReader(payload), manifest, true, nil); err != nil {
+		t.Fatalf("PushResource() error = %v", err)
+	}
+	got := <-received
+	if len(got) != len(payload)-resumeOffset {
+		t.Fatalf("agent received %d bytes, want %d (skipped already-sent prefix)", len(got), len(payload)-resumeOffset)
+	}
+	if !bytes.Equal(got, payload[resumeOffset:]) {
+		t.Errorf("resumed transfer sent wrong bytes: got %q, want %q", got, payload[resumeOffset:])
+	}
+}
+
+func TestPushResourceAgainstRealReceiveResource(t *testing.T) {
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	destDir := t.TempDir()
+	payload := bytes.Repeat([]byte("firmware-bytes"), 2000)
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 97}
+
+	agentErr := make(chan error, 1)
+	go func() { agentErr <- ReceiveResource(agent, destDir) }()
+
+	if err := PushResource(client, bytes.NewReader(payload), manifest, false, nil); err != nil {
+		t.Fatalf("PushResource() error = %v", err)
+	}
+	if err := <-agentErr; err != nil {
+		t.Fatalf("ReceiveResource() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, manifest.Name))
+	if err != nil {
+		t.Fatalf("reading received file: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("received file has %d bytes, want %d matching bytes", len(got), len(payload))
+	}
+}
+
+func TestReceiveResourceResumesFromExistingPartialFile(t *testing.T) {
+	destDir := t.TempDir()
+	payload := bytes.Repeat([]byte("y"), 1000)
+	const alreadyHave = 413
+
+	if err := os.WriteFile(filepath.Join(destDir, "fw.bin"), payload[:alreadyHave], 0o644); err != nil {
+		t.Fatalf("seeding partial file: %v", err)
+	}
+
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 64}
+
+	agentErr := make(chan error, 1)
+	go func() { agentErr <- ReceiveResource(agent, destDir) }()
+
+	if err := PushResource(client, bytes.NewReader(payload), manifest, true, nil); err != nil {
+		t.Fatalf("PushResource() error = %v", err)
+	}
+	if err := <-agentErr; err != nil {
+		t.Fatalf("ReceiveResource() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "fw.bin"))
+	if err != nil {
+		t.Fatalf("reading received file: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("resumed file = %d bytes, want %d matching the full payload", len(got), len(payload))
+	}
+}
+
+func TestReceiveResourceVerifyFailureReportsFailedStatus(t *testing.T) {
+	destDir := t.TempDir()
+	payload := []byte("not what the manifest claims")
+
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	manifest := ResourceManifest{
+		Name:   "fw.bin",
+		Size:   int64(len(payload)),
+		SHA256: "0000000000000000000000000000000000000000000000000000000000000",
+		Verify: true,
+	}
+
+	agentErr := make(chan error, 1)
+	go func() { agentErr <- ReceiveResource(agent, destDir) }()
+
+	err := PushResource(client, bytes.NewReader(payload), manifest, false, nil)
+	if err == nil {
+		t.Fatal("PushResource() expected an error when the agent's verify step fails")
+	}
+	if err := <-agentErr; err != nil {
+		t.Fatalf("ReceiveResource() error = %v", err)
+	}
+}
+
+func TestHandleResourceRequestBridgesToAgentConnection(t *testing.T) {
+	agentConn, serverSideConn := net.Pipe()
+	defer agentConn.Close()
+	defer serverSideConn.Close()
+
+	const mid = "dut-test-mid"
+	cs := &ConnServer{MID: mid, Mode: ModeResource, Conn: serverSideConn, State: NewConnStateTracker()}
+	Agents.Register(cs)
+	defer Agents.Unregister(mid)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleResourceRequest(w, r, mid)
+	}))
+	defer ts.Close()
+
+	destDir := t.TempDir()
+	agentErr := make(chan error, 1)
+	go func() { agentErr <- ReceiveResource(agentConn, destDir) }()
+
+	payload := bytes.Repeat([]byte("over-the-wire"), 500)
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 123}
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	if err := PushResourceOverWS(addr, mid, bytes.NewReader(payload), manifest, false, nil); err != nil {
+		t.Fatalf("PushResourceOverWS() error = %v", err)
+	}
+	if err := <-agentErr; err != nil {
+		t.Fatalf("ReceiveResource() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "fw.bin"))
+	if err != nil {
+		t.Fatalf("reading received file: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("received file has %d bytes, want %d matching bytes", len(got), len(payload))
+	}
+}
+
+func TestPushResourceFailedStatus(t *testing.T) {
+	client, agent := net.Pipe()
+	defer client.Close()
+	defer agent.Close()
+
+	payload := []byte("short")
+	manifest := ResourceManifest{Name: "fw.bin", Size: int64(len(payload)), ChunkSize: 64}
+
+	done := make(chan struct{})
+	go func() { fakeAgent(t, agent, 0, Failed); close(done) }()
+
+	err := PushResource(client, bytes.NewReader(payload), manifest, false, nil)
+	if err == nil {
+		t.Fatal("PushResource() expected an error for a Failed final status")
+	}
+	<-done
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"context"
+	"sync"
+)
+
+// ConnState models an agent's connectivity lifecycle, mirroring the
+// semantics gRPC uses for channel state: an agent starts Idle, moves to
+// Connecting while the socket/TLS handshake is in flight, becomes Ready
+// once registered, falls back to TransientFailure when a ping times out,
+// and ends at Shutdown when the agent is explicitly torn down.
+type ConnState int
+
+const (
+	Idle ConnState = iota
+	Connecting
+	Ready
+	TransientFailure
+	Shutdown
+)
+
+// String implements fmt.Stringer.
+func (s ConnState) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case Connecting:
+		return "Connecting"
+	case Ready:
+		return "Ready"
+	case TransientFailure:
+		return "TransientFailure"
+	case Shutdown:
+		return "Shutdown"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConnStateTracker holds the current ConnState for a single agent and lets
+// subscribers block until it changes, analogous to
+// grpc.ClientConn.WaitForStateChange.
+type ConnStateTracker struct {
+	mu    sync.Mutex
+	state ConnState
+	subs  []chan struct{}
+}
+
+// NewConnStateTracker returns a tracker starting in the Idle state.
+func NewConnStateTracker() *ConnStateTracker {
+	return &ConnStateTracker{state: Idle}
+}
+
+// State returns the current ConnState.
+func (t *ConnStateTracker) State() ConnState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// SetState transitions to the given state and wakes any pending
+// WaitForStateChange callers.
+func (t *ConnStateTracker) SetState(s ConnState) {
+	t.mu.Lock()
+	t.state = s
+	subs := t.subs
+	t.subs = nil
+	t.mu.Unlock()
+
+	for _, ch := range subs {
+		close(ch)
+	}
+}
+
+// WaitForStateChange blocks until the tracker's state differs from last
+// or ctx is done, whichever happens first, returning true for a state
+// change and false when ctx ends the wait. It returns true without
+// blocking if the state already differs from last.
+func (t *ConnStateTracker) WaitForStateChange(ctx context.Context, last ConnState) bool {
+	t.mu.Lock()
+	if t.state != last {
+		t.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	t.subs = append(t.subs, ch)
+	t.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnStateTrackerWaitForStateChange(t *testing.T) {
+	tr := NewConnStateTracker()
+	changed := make(chan bool, 1)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		changed <- tr.WaitForStateChange(ctx, Idle)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	tr.SetState(Connecting)
+
+	if !<-changed {
+		t.Fatal("WaitForStateChange returned false after a real transition")
+	}
+	if got := tr.State(); got != Connecting {
+		t.Errorf("State() = %v, want %v", got, Connecting)
+	}
+}
+
+func TestConnStateTrackerWaitForStateChangeReturnsImmediatelyIfAlreadyChanged(t *testing.T) {
+	tr := NewConnStateTracker()
+	tr.SetState(Ready)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if !tr.WaitForStateChange(ctx, Idle) {
+		t.Fatal("WaitForStateChange should return true immediately when state already differs")
+	}
+}
+
+func TestConnStateTrackerWaitForStateChangeContextCanceled(t *testing.T) {
+	tr := NewConnStateTracker()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if tr.WaitForStateChange(ctx, Idle) {
+		t.Fatal("WaitForStateChange should return false when ctx expires with no transition")
+	}
+}
+
+func TestStatusScoreMappingOrdering(t *testing.T) {
+	order := []string{
+		dutStatusIdle,
+		dutStatusRunning,
+		dutStatusDisconnected,
+		dutStatusFailed,
+		Idle.String(),
+		Connecting.String(),
+		Ready.String(),
+		TransientFailure.String(),
+		Shutdown.String(),
+	}
+	for i := 1; i < len(order); i++ {
+		prev, cur := StatusScoreMapping(order[i-1]), StatusScoreMapping(order[i])
+		if prev >= cur {
+			t.Errorf("StatusScoreMapping(%q)=%d should be < StatusScoreMapping(%q)=%d", order[i-1], prev, order[i], cur)
+		}
+	}
+}
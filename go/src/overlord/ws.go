@@ -0,0 +1,272 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// wsGUID is the magic string RFC 6455 defines for computing
+// Sec-WebSocket-Accept from the client's Sec-WebSocket-Key.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsConn is a minimal RFC 6455 WebSocket connection: just enough framing
+// to carry the binary/text messages the cast and resource-push bridges
+// need, without pulling in an external dependency. It does not support
+// fragmentation, extensions, or compression, and (unlike a spec-pure
+// implementation) WriteMessage never masks, on either side of the
+// connection: the only client-side wsConn, from dialWebSocket, talks to
+// this package's own upgradeWebSocket, whose ReadMessage accepts
+// unmasked frames.
+type wsConn struct {
+	rw io.ReadWriteCloser
+	br *bufio.Reader
+}
+
+const (
+	wsOpText   = 0x1
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+	wsOpPing   = 0x9
+	wsOpPong   = 0xA
+)
+
+// upgradeWebSocket performs the HTTP->WebSocket handshake and hijacks the
+// underlying TCP connection. The caller owns the returned wsConn and must
+// Close it.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("overlord: missing Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("overlord: response writer does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := computeWebSocketAccept(key)
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := rw.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{rw: conn, br: rw.Reader}, nil
+}
+
+// dialWebSocket opens a TCP connection to addr and performs the client
+// side of the RFC 6455 handshake against path, returning a wsConn ready
+// for ReadMessage/WriteMessage. It's the counterpart to upgradeWebSocket,
+// used by ghost to reach the resource-push bridge over HTTP instead of
+// dialing the agent socket port directly.
+func dialWebSocket(addr, path string) (*wsConn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := randomWebSocketKey()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	req := fmt.Sprintf(
+		"GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		path, addr, key)
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	status, err := br.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(status, "HTTP/1.1 101") {
+		conn.Close()
+		return nil, fmt.Errorf("overlord: websocket handshake failed: %s", strings.TrimSpace(status))
+	}
+	accept := ""
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Sec-WebSocket-Accept") {
+			accept = strings.TrimSpace(value)
+		}
+	}
+	if accept != computeWebSocketAccept(key) {
+		conn.Close()
+		return nil, errors.New("overlord: invalid Sec-WebSocket-Accept")
+	}
+
+	return &wsConn{rw: conn, br: br}, nil
+}
+
+// randomWebSocketKey generates the client's Sec-WebSocket-Key: 16 random
+// bytes, base64-encoded, per RFC 6455 4.1.
+func randomWebSocketKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// computeWebSocketAccept implements the RFC 6455 4.2.2 handshake response
+// derivation; split out so it can be unit tested against the RFC's own
+// worked example without a live socket.
+func computeWebSocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ReadMessage reads a single, unfragmented WebSocket frame and returns its
+// opcode and payload. Masked client frames are unmasked transparently.
+func (c *wsConn) ReadMessage() (opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	if !fin {
+		return 0, nil, errors.New("overlord: fragmented frames not supported")
+	}
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.br, ext); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// WriteMessage writes a single unmasked WebSocket frame, on both the
+// server and the client side.
+func (c *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(len(payload)))
+		header = append(header, 126)
+		header = append(header, ext...)
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(len(payload)))
+		header = append(header, 127)
+		header = append(header, ext...)
+	}
+
+	if _, err := c.rw.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) Close() error {
+	return c.rw.Close()
+}
+
+// wsByteStream adapts a wsConn into a plain io.ReadWriter by treating each
+// WebSocket message as one chunk of an ordinary byte stream: Write sends
+// one binary message per call (matching how json.Encoder issues exactly
+// one Write per encoded value), and Read drains messages into an internal
+// buffer as needed. This is what lets the resource-push protocol's
+// json.Encoder/Decoder pair run unmodified over a WebSocket, the same way
+// they already run over a raw net.Conn.
+type wsByteStream struct {
+	ws  *wsConn
+	buf []byte
+}
+
+func newWSByteStream(ws *wsConn) *wsByteStream {
+	return &wsByteStream{ws: ws}
+}
+
+func (s *wsByteStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		_, payload, err := s.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		s.buf = payload
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *wsByteStream) Write(p []byte) (int, error) {
+	if err := s.ws.WriteMessage(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2015 The Chromium OS Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package overlord
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestComputeWebSocketAccept checks against the worked example from RFC
+// 6455 section 1.3.
+func TestComputeWebSocketAccept(t *testing.T) {
+	got := computeWebSocketAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	want := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if got != want {
+		t.Errorf("computeWebSocketAccept() = %q, want %q", got, want)
+	}
+}
+
+// pipeRWC adapts a pair of pipes into a single io.ReadWriteCloser so
+// wsConn's frame codec can be exercised without a real socket.
+type pipeRWC struct {
+	io.Reader
+	io.Writer
+}
+
+func (pipeRWC) Close() error { return nil }
+
+func TestWsConnFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	c := &wsConn{rw: pipeRWC{Reader: &buf, Writer: &buf}, br: bufio.NewReader(&buf)}
+
+	want := []byte("hello, cast viewer")
+	if err := c.WriteMessage(wsOpBinary, want); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	opcode, payload, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if opcode != wsOpBinary {
+		t.Errorf("opcode = %d, want %d", opcode, wsOpBinary)
+	}
+	if !bytes.Equal(payload, want) {
+		t.Errorf("payload = %q, want %q", payload, want)
+	}
+}
+
+func TestWsConnReadMaskedClientFrame(t *testing.T) {
+	payload := []byte("ping")
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	var frame bytes.Buffer
+	frame.WriteByte(0x80 | wsOpText) // FIN + text
+	frame.WriteByte(0x80 | byte(len(payload)))
+	frame.Write(mask[:])
+	frame.Write(masked)
+
+	c := &wsConn{br: bufio.NewReader(&frame)}
+	opcode, got, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if opcode != wsOpText {
+		t.Errorf("opcode = %d, want %d", opcode, wsOpText)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %q, want %q", got, payload)
+	}
+}